@@ -1,15 +1,123 @@
 package regex
 
-type Regex *nfaState
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Regex is a compiled pattern. It owns a DFA cache keyed off its own NFA
+// states, lazily filled in as it matches strings, so repeated matches against
+// the same compiled Regex don't redo the subset construction.
+type Regex struct {
+	root  *nfaState
+	cache *dfaCache
+}
+
+// Sentinel errors returned by Compile, identifying the kind of problem found
+// in the source pattern. Use errors.Is to test for a specific one; a
+// *CompileError additionally carries the byte offset where it was detected.
+var (
+	ErrUnmatchedLpar     = errors.New("unmatched (")
+	ErrUnmatchedRpar     = errors.New("unmatched )")
+	ErrBareAlternation   = errors.New("| with no preceding expression")
+	ErrEmptyExpression   = errors.New("empty expression")
+	ErrTrailingOperator  = errors.New("operator with no following expression")
+	ErrMissingOperand    = errors.New("*, +, or ? with no preceding expression")
+	ErrUnterminatedClass = errors.New("unterminated character class")
+	ErrInvalidClassRange = errors.New("character class range is out of order, e.g. [z-a]")
+)
+
+// CompileError reports a problem found while compiling a pattern, and where
+// in the source string it was found.
+type CompileError struct {
+	Err    error
+	Offset int
+}
 
-func Compile(str string) Regex {
-	return nfa(postfix(preprocess(str)))
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("regex: %s at offset %d", e.Err, e.Offset)
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+func Compile(str string) (Regex, error) {
+	preprocessed, classes, err := preprocess(str)
+	if err != nil {
+		return Regex{}, err
+	}
+
+	root := nfa(postfix(preprocessed), classes)
+	return Regex{root: root, cache: newDFACache()}, nil
+}
+
+// MustCompile is like Compile but panics if str fails to compile. It's
+// meant for patterns known at init time, not ones derived from input.
+func MustCompile(str string) Regex {
+	regex, err := Compile(str)
+	if err != nil {
+		panic(err)
+	}
+	return regex
 }
 
 func Match(regex Regex, str string) bool {
 	return match(regex, str)
 }
 
+// Find returns the leftmost-longest match of regex in str, as byte offsets
+// into str, along with whether a match was found at all.
+func Find(regex Regex, str string) (start, end int, ok bool) {
+	return findFrom(regex, str, 0)
+}
+
+// FindAll returns the byte offsets of all non-overlapping leftmost-longest
+// matches of regex in str, scanning left to right. Like stdlib regexp, an
+// empty match immediately following a non-empty one is suppressed, since
+// otherwise every non-empty match that a nullable pattern can also match
+// emptily would be reported twice.
+func FindAll(regex Regex, str string) [][2]int {
+	result := make([][2]int, 0)
+	prevMatchEnd := -1
+
+	for pos := 0; pos <= len(str); {
+		start, end, ok := findFrom(regex, str, pos)
+		if !ok {
+			break
+		}
+
+		if start == end && start == prevMatchEnd {
+			// Zero-length match: step by one rune to make progress.
+			_, size := utf8.DecodeRuneInString(str[end:])
+			if size == 0 {
+				break
+			}
+			pos = end + size
+			continue
+		}
+
+		result = append(result, [2]int{start, end})
+		prevMatchEnd = end
+
+		if end > pos {
+			pos = end
+		} else {
+			// Zero-length match: step by one rune to make progress.
+			_, size := utf8.DecodeRuneInString(str[end:])
+			if size == 0 {
+				break
+			}
+			pos = end + size
+		}
+	}
+
+	return result
+}
+
 // private
 
 const (
@@ -17,9 +125,99 @@ const (
 	opGroupEnd   = ')'
 	opAnd        = '&'
 	opOr         = '|'
+	opStar       = '*'
+	opPlus       = '+'
+	opQuestion   = '?'
+)
+
+var operators = [...]rune{opGroupStart, opGroupEnd, opAnd, opOr, opStar, opPlus, opQuestion}
+
+// metaBOT, metaEOT, and metaAny are atoms, not operators: preprocess and
+// postfix treat them exactly like a regular literal char. A whole `[...]`
+// class, from metaClassStart to metaClassEnd, is likewise one atom, which
+// preprocess replaces with a single placeholder rune.
+const (
+	metaBOT        = '^'
+	metaEOT        = '$'
+	metaAny        = '.'
+	metaClassStart = '['
+	metaClassEnd   = ']'
 )
 
-var operators = [...]rune{opGroupStart, opGroupEnd, opAnd, opOr}
+// classPlaceholderBase is the first of a run of Unicode Private Use Area
+// runes preprocess substitutes for `[...]` character classes, so that the
+// rest of the pipeline (postfix, nfa) can keep treating every atom as a
+// single rune. The rune's offset from classPlaceholderBase is the index into
+// the classes slice threaded alongside the preprocessed string.
+const classPlaceholderBase = 0xE000
+
+// charClass is the parsed form of a `[...]` or `[^...]` bracket expression:
+// a set of inclusive rune ranges, optionally negated.
+type charClass struct {
+	ranges  [][2]rune
+	negated bool
+}
+
+// Parses a `[...]` character class starting at runes[start] == '['.
+// Returns the parsed class and the index right after the closing ']'. On
+// error, the returned int is instead the index just past the out-of-order
+// range, or len(runes) for a class with no closing ']'.
+func parseClass(runes []rune, start int) (charClass, int, error) {
+	i := start + 1
+
+	negated := false
+	if i < len(runes) && runes[i] == '^' {
+		negated = true
+		i++
+	}
+
+	var ranges [][2]rune
+
+	for i < len(runes) && runes[i] != metaClassEnd {
+		lo, next := readClassRune(runes, i)
+		i = next
+
+		if i < len(runes)-1 && runes[i] == '-' && runes[i+1] != metaClassEnd {
+			i++ // skip '-'
+			hi, next := readClassRune(runes, i)
+			i = next
+
+			if hi < lo {
+				return charClass{}, i, ErrInvalidClassRange
+			}
+			ranges = append(ranges, [2]rune{lo, hi})
+		} else {
+			ranges = append(ranges, [2]rune{lo, lo})
+		}
+	}
+
+	if i >= len(runes) {
+		return charClass{}, i, ErrUnterminatedClass
+	}
+
+	return charClass{ranges: ranges, negated: negated}, i + 1, nil
+}
+
+// Reads a single class member at runes[i], resolving a `\x` escape to the
+// literal rune x. Returns the rune and the index of the following member.
+func readClassRune(runes []rune, i int) (rune, int) {
+	if runes[i] == '\\' && i+1 < len(runes) {
+		return runes[i+1], i + 2
+	}
+	return runes[i], i + 1
+}
+
+// Reports whether char is in the class, honoring negation.
+func (c charClass) matches(char rune) bool {
+	inRange := false
+	for _, r := range c.ranges {
+		if char >= r[0] && char <= r[1] {
+			inRange = true
+			break
+		}
+	}
+	return inRange != c.negated
+}
 
 // The higher the index, the higher the precedence
 var operatorPrecedence = [...]rune{opOr, opAnd}
@@ -45,24 +243,72 @@ func precedence(char rune) int {
 	return result
 }
 
-// Inserts opAnd in the source string
-func preprocess(str string) string {
+// Inserts opAnd in the source string, and replaces each `[...]` character
+// class with a private-use placeholder rune. Returns the rewritten string
+// together with the classes referenced by those placeholders, in order. Any
+// structural problem in str (unmatched parens, bare or trailing `|`, a
+// closure operator with nothing to close over, empty parens or an empty
+// pattern, or a malformed character class) is reported as a *CompileError
+// instead.
+func preprocess(str string) (string, []charClass, error) {
+	runes := []rune(str)
+	if len(runes) == 0 {
+		return "", nil, &CompileError{Err: ErrEmptyExpression, Offset: 0}
+	}
+
+	// byteOffsets[i] is the byte offset of runes[i] in str; byteOffsets[len(runes)]
+	// is len(str), so it's safe to index with the rune position just past the
+	// last rune (e.g. an unterminated character class).
+	byteOffsets := make([]int, 0, len(runes)+1)
+	for idx := range str {
+		byteOffsets = append(byteOffsets, idx)
+	}
+	byteOffsets = append(byteOffsets, len(str))
+
 	result := make([]rune, 0, 1024)
+	classes := make([]charClass, 0)
 
-	// Count of groups we are currently nested in
-	groupStackCounter := 0
+	// Byte offsets of the '(' of groups we are currently nested in
+	groupStack := make([]int, 0, 8)
 	// Previous char expects to be connected to the next one with opAnd
 	wantsAnd := false
 	// Previous char allows the current char to be opOr
 	canOr := false
 	// Previous char was opOr
 	lastOr := false
+	// Byte offset of the opOr that set lastOr
+	lastOrOffset := 0
+
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+
+		if char == metaClassStart {
+			class, next, err := parseClass(runes, i)
+			if err != nil {
+				return "", nil, &CompileError{Err: err, Offset: byteOffsets[next]}
+			}
+			classes = append(classes, class)
+			i = next - 1
+
+			// And
+			if wantsAnd {
+				result = append(result, opAnd)
+			}
+			wantsAnd = true
+
+			// Or
+			canOr = true
+			lastOr = false
+
+			// Result
+			result = append(result, classPlaceholderBase+rune(len(classes)-1))
+			continue
+		}
 
-	for _, char := range str {
 		switch char {
 		case opGroupStart:
 			// Group
-			groupStackCounter = groupStackCounter + 1
+			groupStack = append(groupStack, byteOffsets[i])
 
 			// And
 			if wantsAnd {
@@ -78,16 +324,22 @@ func preprocess(str string) string {
 			result = append(result, char)
 		case opGroupEnd:
 			// Group
-			groupStackCounter = groupStackCounter - 1
+			if len(groupStack) == 0 {
+				return "", nil, &CompileError{Err: ErrUnmatchedRpar, Offset: byteOffsets[i]}
+			}
+			if lastOr {
+				return "", nil, &CompileError{Err: ErrTrailingOperator, Offset: lastOrOffset}
+			}
+			if !wantsAnd && !canOr {
+				return "", nil, &CompileError{Err: ErrEmptyExpression, Offset: groupStack[len(groupStack)-1]}
+			}
+			groupStack = groupStack[:len(groupStack)-1]
 
 			// And
 			wantsAnd = true
 
 			// Or
 			canOr = true
-			if lastOr {
-				panic("or misused")
-			}
 			lastOr = false
 
 			// Result
@@ -98,10 +350,27 @@ func preprocess(str string) string {
 
 			// Or
 			if !canOr {
-				panic("or misused")
+				return "", nil, &CompileError{Err: ErrBareAlternation, Offset: byteOffsets[i]}
 			}
 			canOr = false
 			lastOr = true
+			lastOrOffset = byteOffsets[i]
+
+			// Result
+			result = append(result, char)
+		case opStar, opPlus, opQuestion:
+			// A unary operator closes over the atom or group that precedes it,
+			// so it never wants an opAnd inserted before itself.
+			if !canOr {
+				return "", nil, &CompileError{Err: ErrMissingOperand, Offset: byteOffsets[i]}
+			}
+
+			// And
+			wantsAnd = true
+
+			// Or
+			canOr = true
+			lastOr = false
 
 			// Result
 			result = append(result, char)
@@ -121,11 +390,15 @@ func preprocess(str string) string {
 		}
 	}
 
-	if groupStackCounter != 0 {
-		panic("group mismatch")
+	if lastOr {
+		return "", nil, &CompileError{Err: ErrTrailingOperator, Offset: lastOrOffset}
 	}
 
-	return string(result)
+	if len(groupStack) != 0 {
+		return "", nil, &CompileError{Err: ErrUnmatchedLpar, Offset: groupStack[len(groupStack)-1]}
+	}
+
+	return string(result), classes, nil
 }
 
 type operatorStack struct{ buf []rune }
@@ -160,6 +433,12 @@ func postfix(str string) string {
 			case opGroupStart:
 				// Group start lands on the stack and waits there for group end
 				operatorStack.Push(char)
+			case opStar, opPlus, opQuestion:
+				// Unary postfix operators bind tighter than anything that could be
+				// waiting on the stack, and their single operand is already in the
+				// output, so they go straight to output too (right-associative
+				// Shunting-Yard unary rule).
+				result = append(result, char)
 			case opGroupEnd:
 				// Group end pops all the operators from the stack until it meets a group start
 				for {
@@ -206,15 +485,21 @@ const (
 	nfaStateKindChar int = iota
 	nfaStateKindSplit
 	nfaStateKindMatch
+	nfaStateKindBOT
+	nfaStateKindEOT
+	nfaStateKindAny
+	nfaStateKindClass
 )
 
 // Each state in the automaton is either a match, a single matching char leading to the
-// next state, or a split leading to two states.
+// next state, or a split leading to two states. class is only set when kind is
+// nfaStateKindClass.
 type nfaState struct {
-	char rune
-	kind int
-	out1 *nfaState
-	out2 *nfaState
+	char  rune
+	class charClass
+	kind  int
+	out1  *nfaState
+	out2  *nfaState
 }
 
 // A fragment is a part of the state graph. It has a start and lists of output arrows.
@@ -247,11 +532,20 @@ func (stack *nfaFragStack) Pop() *nfaFrag {
 }
 
 // Creates a set of interconnnected states that make up an NFA for matching
-// the given regex in postfix form
-func nfa(str string) *nfaState {
+// the given regex in postfix form. classes are the character classes
+// referenced by the placeholder runes preprocess substituted into str.
+func nfa(str string, classes []charClass) *nfaState {
 	fragStack := newNfaFragStack()
 
 	for _, char := range str {
+		if char >= classPlaceholderBase && int(char-classPlaceholderBase) < len(classes) {
+			// [...] / [^...] character class
+			state := &nfaState{kind: nfaStateKindClass, class: classes[char-classPlaceholderBase]}
+			frag := &nfaFrag{start: state, outs: []**nfaState{&state.out1}}
+			fragStack.Push(frag)
+			continue
+		}
+
 		switch char {
 		case opAnd:
 			// opAnd connects the last two fragments
@@ -267,6 +561,44 @@ func nfa(str string) *nfaState {
 			state := &nfaState{kind: nfaStateKindSplit, out1: frag1.start, out2: frag2.start}
 			frag := &nfaFrag{start: state, outs: append(frag1.outs, frag2.outs...)}
 			fragStack.Push(frag)
+		case opStar:
+			// opStar (zero or more) loops the fragment back on itself through a
+			// split, whose other arm is the new dangling out.
+			inner := fragStack.Pop()
+			state := &nfaState{kind: nfaStateKindSplit, out1: inner.start}
+			connectNfaFrag(inner, state)
+			frag := &nfaFrag{start: state, outs: []**nfaState{&state.out2}}
+			fragStack.Push(frag)
+		case opPlus:
+			// opPlus (one or more) runs the fragment once, then splits between
+			// looping back to its start and the new dangling out.
+			inner := fragStack.Pop()
+			state := &nfaState{kind: nfaStateKindSplit, out1: inner.start}
+			connectNfaFrag(inner, state)
+			frag := &nfaFrag{start: inner.start, outs: []**nfaState{&state.out2}}
+			fragStack.Push(frag)
+		case opQuestion:
+			// opQuestion (zero or one) splits between the fragment and a new
+			// dangling out, joining the fragment's own outs with that new one.
+			inner := fragStack.Pop()
+			state := &nfaState{kind: nfaStateKindSplit, out1: inner.start}
+			frag := &nfaFrag{start: state, outs: append(inner.outs, &state.out2)}
+			fragStack.Push(frag)
+		case metaBOT:
+			// ^ only lets its thread through when checked at position 0; see appendState.
+			state := &nfaState{kind: nfaStateKindBOT}
+			frag := &nfaFrag{start: state, outs: []**nfaState{&state.out1}}
+			fragStack.Push(frag)
+		case metaEOT:
+			// $ only lets its thread through when checked at the end of the string; see appendState.
+			state := &nfaState{kind: nfaStateKindEOT}
+			frag := &nfaFrag{start: state, outs: []**nfaState{&state.out1}}
+			fragStack.Push(frag)
+		case metaAny:
+			// . matches any single rune except newline, same as Go's regexp default.
+			state := &nfaState{kind: nfaStateKindAny}
+			frag := &nfaFrag{start: state, outs: []**nfaState{&state.out1}}
+			fragStack.Push(frag)
 		default:
 			// regular non-op char adds a new state with one dangling arrow
 			state := &nfaState{char: char, kind: nfaStateKindChar}
@@ -283,39 +615,366 @@ func nfa(str string) *nfaState {
 	return result.start
 }
 
-// Adds the state to the list of states.
-// If the state is a split, recursively adds its children.
-func appendState(stateList []*nfaState, state *nfaState) []*nfaState {
-	if state.kind == nfaStateKindSplit {
-		return appendState(appendState(stateList, state.out1), state.out2)
-	} else {
-		return append(stateList, state)
+// Adds the state to the list of states, following the epsilon closure of
+// split states and anchors. seen guards against a state being added twice in
+// the same closure, which also protects against infinite recursion on
+// epsilon loops introduced by closures such as (a*)*.
+//
+// atStart and atEnd say whether the closure is being computed at the very
+// start, or the very end, of the string being matched; an anchor whose
+// condition doesn't hold is kept as a leaf in the state list instead of
+// being followed, so it can be re-examined later (see dfaCache.atEndOfText)
+// rather than being lost. This keeps closures independent of the byte
+// position they're reached at mid-string, which is what makes them safe to
+// cache in the DFA below.
+func appendState(stateList []*nfaState, state *nfaState, seen map[*nfaState]bool, atStart, atEnd bool) []*nfaState {
+	if seen[state] {
+		return stateList
 	}
+	seen[state] = true
+
+	switch state.kind {
+	case nfaStateKindSplit:
+		return appendState(appendState(stateList, state.out1, seen, atStart, atEnd), state.out2, seen, atStart, atEnd)
+	case nfaStateKindBOT:
+		if atStart {
+			return appendState(stateList, state.out1, seen, atStart, atEnd)
+		}
+	case nfaStateKindEOT:
+		if atEnd {
+			return appendState(stateList, state.out1, seen, atStart, atEnd)
+		}
+	}
+
+	return append(stateList, state)
 }
 
-// Uses the given nfa to match a string.
-func match(nfa *nfaState, str string) bool {
-	currentStates := make([]*nfaState, 0, 1024)
-	nextStates := make([]*nfaState, 0, 1024)
+// Reports whether any of the given states is a match state.
+func hasMatchState(states []*nfaState) bool {
+	for _, state := range states {
+		if state.kind == nfaStateKindMatch {
+			return true
+		}
+	}
 
-	currentStates = appendState(currentStates, nfa)
+	return false
+}
 
-	for _, char := range str {
-		for _, state := range currentStates {
-			if state.char == char {
-				nextStates = appendState(nextStates, state.out1)
-			}
+// maxDFAStates bounds how many distinct NFA-state-sets a single Regex's DFA
+// cache will hold at once, so a pathological pattern or input (e.g. one with
+// huge character classes) can't grow the cache without bound.
+const maxDFAStates = 4096
+
+// dfaState is one node of the lazily-built DFA: a canonicalized set of NFA
+// states reached by the same run of input so far, plus its memoized
+// per-rune transitions.
+type dfaState struct {
+	states      []*nfaState
+	isMatch     bool
+	transitions map[rune]*dfaState
+	lastUsed    int
+}
+
+// dfaCache maps canonicalized NFA-state-sets to their dfaState, so that the
+// (expensive, move + epsilon-closure) subset construction for a given set of
+// NFA states only ever has to run once per Regex.
+type dfaCache struct {
+	byKey map[string]*dfaState
+	clock int
+}
+
+func newDFACache() *dfaCache {
+	return &dfaCache{byKey: make(map[string]*dfaState)}
+}
+
+// canonicalKey turns a (already deduplicated) set of NFA states into a
+// representation that's stable regardless of the order the states were
+// discovered in, so that two closures reaching the same set of states always
+// map to the same dfaState.
+func canonicalKey(states []*nfaState) string {
+	pointers := make([]string, len(states))
+	for i, state := range states {
+		pointers[i] = fmt.Sprintf("%p", state)
+	}
+	sort.Strings(pointers)
+	return strings.Join(pointers, ",")
+}
+
+// getOrCreate returns the dfaState for the given NFA-state-set, creating and
+// caching it if this is the first time the set has been seen.
+func (cache *dfaCache) getOrCreate(states []*nfaState) *dfaState {
+	key := canonicalKey(states)
+
+	if existing, ok := cache.byKey[key]; ok {
+		cache.touch(existing)
+		return existing
+	}
+
+	if len(cache.byKey) >= maxDFAStates {
+		cache.evictLeastRecentlyUsed()
+	}
+
+	state := &dfaState{states: states, isMatch: hasMatchState(states), transitions: make(map[rune]*dfaState)}
+	cache.byKey[key] = state
+	cache.touch(state)
+	return state
+}
+
+func (cache *dfaCache) touch(state *dfaState) {
+	cache.clock++
+	state.lastUsed = cache.clock
+}
+
+// evictLeastRecentlyUsed drops the least-recently-used quarter of the cached
+// DFA states. This is a coarser sweep than a strict LRU list, but it bounds
+// memory for pathological patterns without the bookkeeping of one.
+func (cache *dfaCache) evictLeastRecentlyUsed() {
+	type keyed struct {
+		key   string
+		state *dfaState
+	}
+
+	entries := make([]keyed, 0, len(cache.byKey))
+	for key, state := range cache.byKey {
+		entries = append(entries, keyed{key, state})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].state.lastUsed < entries[j].state.lastUsed })
+
+	evictCount := len(entries) / 4
+	if evictCount == 0 {
+		evictCount = 1
+	}
+	for _, entry := range entries[:evictCount] {
+		delete(cache.byKey, entry.key)
+	}
+
+	// Surviving states may still hold transitions into evicted ones; drop
+	// them so evicted states don't stay reachable through the cache.
+	for _, state := range cache.byKey {
+		state.transitions = make(map[rune]*dfaState)
+	}
+}
+
+// next returns the dfaState reached from `from` by consuming char, computing
+// and caching the move + epsilon-closure on a cache miss. The resulting
+// closure is always computed as mid-string: it's reached after consuming
+// char, so it's never at the start of the input, and the end of the input is
+// handled separately by atEndOfText.
+func (cache *dfaCache) next(from *dfaState, char rune) *dfaState {
+	cache.touch(from)
+
+	if target, ok := from.transitions[char]; ok {
+		return target
+	}
+
+	nextStates := make([]*nfaState, 0, len(from.states))
+	seen := make(map[*nfaState]bool)
+	for _, state := range from.states {
+		matches := false
+		switch state.kind {
+		case nfaStateKindChar:
+			matches = state.char == char
+		case nfaStateKindAny:
+			matches = char != '\n'
+		case nfaStateKindClass:
+			matches = state.class.matches(char)
 		}
 
-		currentStates = nextStates
-		nextStates = nextStates[:0]
+		if matches {
+			nextStates = appendState(nextStates, state.out1, seen, false, false)
+		}
 	}
 
-	for _, state := range currentStates {
-		if state.kind == nfaStateKindMatch {
-			return true
+	target := cache.getOrCreate(nextStates)
+	from.transitions[char] = target
+	return target
+}
+
+// atEndOfText re-closes `from`'s state set as if it were being examined at
+// the end of the string, letting any $ anchors left dangling as leaves (see
+// appendState) resolve. It isn't memoized on `from.transitions` since it
+// isn't keyed by a rune, but it's cheap: it runs at most once per match.
+func (cache *dfaCache) atEndOfText(from *dfaState) *dfaState {
+	states := make([]*nfaState, 0, len(from.states))
+	seen := make(map[*nfaState]bool)
+	for _, state := range from.states {
+		states = appendState(states, state, seen, false, true)
+	}
+
+	return cache.getOrCreate(states)
+}
+
+// Runs regex's DFA starting at byte offset from in str, and returns the
+// furthest byte offset at which a match state was reached, preferring the
+// longest run (per leftmost-longest semantics). ok is false if no match
+// state was ever reached starting from from.
+func longestMatchFrom(regex Regex, str string, from int) (end int, ok bool) {
+	strLen := len(str)
+
+	startStates := appendState(nil, regex.root, make(map[*nfaState]bool), from == 0, from == strLen)
+	current := regex.cache.getOrCreate(startStates)
+
+	if current.isMatch {
+		end, ok = from, true
+	}
+
+	pos := from
+	for _, char := range str[from:] {
+		if len(current.states) == 0 {
+			break
+		}
+
+		current = regex.cache.next(current, char)
+		pos += utf8.RuneLen(char)
+
+		if pos == strLen {
+			current = regex.cache.atEndOfText(current)
+		}
+
+		if current.isMatch {
+			end, ok = pos, true
 		}
 	}
 
-	return false
+	return end, ok
+}
+
+// Uses the regex's nfa to match a string in full, from start to end.
+func match(regex Regex, str string) bool {
+	end, ok := longestMatchFrom(regex, str, 0)
+	return ok && end == len(str)
+}
+
+// addThread is appendState plus per-thread start tracking: it closes state
+// into stateList exactly as appendState does, but also records in starts
+// the byte offset (threadStart) the thread reaching it began at. Threads
+// are always merged in start-ascending order (see findFrom), so seen's
+// usual dedup guarantee — a state already present is left alone — doubles
+// as "the earliest-started thread to reach a state owns it", since from a
+// shared state onward two threads have identical futures and leftmost
+// always beats longest.
+func addThread(stateList []*nfaState, starts map[*nfaState]int, seen map[*nfaState]bool, state *nfaState, threadStart int, atStart, atEnd bool) []*nfaState {
+	if seen[state] {
+		return stateList
+	}
+	seen[state] = true
+
+	switch state.kind {
+	case nfaStateKindSplit:
+		stateList = addThread(stateList, starts, seen, state.out1, threadStart, atStart, atEnd)
+		return addThread(stateList, starts, seen, state.out2, threadStart, atStart, atEnd)
+	case nfaStateKindBOT:
+		if atStart {
+			return addThread(stateList, starts, seen, state.out1, threadStart, atStart, atEnd)
+		}
+	case nfaStateKindEOT:
+		if atEnd {
+			return addThread(stateList, starts, seen, state.out1, threadStart, atStart, atEnd)
+		}
+	}
+
+	starts[state] = threadStart
+	return append(stateList, state)
+}
+
+// Finds the leftmost-longest match starting at or after from in a single
+// forward pass over str. Rather than restarting a whole simulation at each
+// candidate start offset (quadratic for patterns with no match nearby), it
+// runs one multi-thread NFA simulation: a new thread is injected at every
+// rune boundary until a match is found, and starts records the offset each currently active state's
+// thread began at, so the leftmost match is whichever thread resolves to
+// the match state with the smallest recorded start.
+func findFrom(regex Regex, str string, from int) (start, end int, ok bool) {
+	strLen := len(str)
+
+	active := make([]*nfaState, 0)
+	starts := make(map[*nfaState]int)
+	bestStart, bestEnd := -1, 0
+
+	inject := func(pos int) {
+		seen := make(map[*nfaState]bool, len(active))
+		for _, state := range active {
+			seen[state] = true
+		}
+		active = addThread(active, starts, seen, regex.root, pos, pos == 0, pos == strLen)
+	}
+
+	// checkMatch records the current position as the best match end if the
+	// match state is active, with its thread's start improving on (i.e.
+	// smaller than, or equal with a longer run than) the best found so far.
+	// There's only ever one match-kind state in the whole NFA, so at most
+	// one thread can be holding it at a time.
+	checkMatch := func(pos int) {
+		for _, state := range active {
+			if state.kind != nfaStateKindMatch {
+				continue
+			}
+			if s := starts[state]; bestStart == -1 || s < bestStart || (s == bestStart && pos > bestEnd) {
+				bestStart, bestEnd = s, pos
+			}
+			break
+		}
+	}
+
+	pos := from
+	inject(pos)
+	checkMatch(pos)
+
+	for pos < strLen {
+		// Once a match is found, no thread can still beat it once the
+		// active set has run dry: nothing is left to extend it, and no new
+		// threads are injected past this point (below).
+		if len(active) == 0 && bestStart != -1 {
+			break
+		}
+
+		char, size := utf8.DecodeRuneInString(str[pos:])
+		nextPos := pos + size
+		atEnd := nextPos == strLen
+
+		next := make([]*nfaState, 0, len(active))
+		// next gets its own starts map, so recording a start for a state in
+		// next can't overwrite the start of that same state still waiting in
+		// active to be stepped.
+		nextStarts := make(map[*nfaState]int, len(active))
+		seen := make(map[*nfaState]bool, len(active))
+		for _, state := range active {
+			// A thread that can no longer beat the best match already
+			// found is dead weight; drop it instead of carrying it
+			// forward indefinitely.
+			if bestStart != -1 && starts[state] > bestStart {
+				continue
+			}
+
+			matches := false
+			switch state.kind {
+			case nfaStateKindChar:
+				matches = state.char == char
+			case nfaStateKindAny:
+				matches = char != '\n'
+			case nfaStateKindClass:
+				matches = state.class.matches(char)
+			}
+
+			if matches {
+				next = addThread(next, nextStarts, seen, state.out1, starts[state], false, atEnd)
+			}
+		}
+
+		active = next
+		starts = nextStarts
+		pos = nextPos
+
+		if bestStart == -1 {
+			inject(pos)
+		}
+
+		checkMatch(pos)
+	}
+
+	if bestStart == -1 {
+		return 0, 0, false
+	}
+
+	return bestStart, bestEnd, true
 }
@@ -1,12 +1,14 @@
 package regex_test
 
 import (
+	"errors"
 	"regex/pkg/regex"
+	"strings"
 	"testing"
 )
 
 func TestRegex(t *testing.T) {
-	r := regex.Compile("a(b|cd|ef)g")
+	r := regex.MustCompile("a(b|cd|ef)g")
 	assertMatch(t, r, "abg")
 	assertMatch(t, r, "acdg")
 	assertMatch(t, r, "aefg")
@@ -17,6 +19,231 @@ func TestRegex(t *testing.T) {
 	refuteMatch(t, r, "ef")
 }
 
+func TestRegexClosures(t *testing.T) {
+	star := regex.MustCompile("a*b")
+	assertMatch(t, star, "b")
+	assertMatch(t, star, "ab")
+	assertMatch(t, star, "aaaab")
+	refuteMatch(t, star, "a")
+	refuteMatch(t, star, "aaa")
+
+	plus := regex.MustCompile("a+")
+	assertMatch(t, plus, "a")
+	assertMatch(t, plus, "aaaa")
+	refuteMatch(t, plus, "")
+	refuteMatch(t, plus, "b")
+
+	question := regex.MustCompile("ab?c")
+	assertMatch(t, question, "ac")
+	assertMatch(t, question, "abc")
+	refuteMatch(t, question, "abbc")
+	refuteMatch(t, question, "a")
+
+	nested := regex.MustCompile("(ab)*|c+")
+	assertMatch(t, nested, "")
+	assertMatch(t, nested, "ab")
+	assertMatch(t, nested, "ababab")
+	assertMatch(t, nested, "c")
+	assertMatch(t, nested, "ccc")
+	refuteMatch(t, nested, "a")
+	refuteMatch(t, nested, "abc")
+}
+
+func TestRegexAnchorsAndWildcard(t *testing.T) {
+	anchored := regex.MustCompile("^abc$")
+	assertMatch(t, anchored, "abc")
+	refuteMatch(t, anchored, "xabc")
+	refuteMatch(t, anchored, "abcx")
+	refuteMatch(t, anchored, "ab")
+
+	wildcard := regex.MustCompile("a.c")
+	assertMatch(t, wildcard, "abc")
+	assertMatch(t, wildcard, "azc")
+	refuteMatch(t, wildcard, "ac")
+	refuteMatch(t, wildcard, "abbc")
+
+	empty := regex.MustCompile("^$")
+	assertMatch(t, empty, "")
+	refuteMatch(t, empty, "a")
+
+	anchoredStar := regex.MustCompile("^a.*")
+	start, end, ok := regex.Find(anchoredStar, "aXYZ")
+	if !ok || start != 0 || end != 4 {
+		t.Errorf("Expected match at [0, 4], got [%d, %d], ok=%v", start, end, ok)
+	}
+	if _, _, ok := regex.Find(anchoredStar, "ba"); ok {
+		t.Errorf("Expected ^a.* not to match when 'a' isn't at position 0")
+	}
+}
+
+func TestRegexCharClasses(t *testing.T) {
+	lower := regex.MustCompile("[a-z]+")
+	assertMatch(t, lower, "hello")
+	refuteMatch(t, lower, "Hello")
+	refuteMatch(t, lower, "")
+
+	hex := regex.MustCompile("[0-9A-Fa-f]+")
+	assertMatch(t, hex, "1a2B3c")
+	refuteMatch(t, hex, "1a2g")
+
+	negated := regex.MustCompile("[^0-9]+")
+	assertMatch(t, negated, "abc")
+	refuteMatch(t, negated, "abc1")
+
+	escaped := regex.MustCompile(`[\]\\]+`)
+	assertMatch(t, escaped, `]\]\`)
+	refuteMatch(t, escaped, "a")
+
+	single := regex.MustCompile("[a-a]")
+	assertMatch(t, single, "a")
+	refuteMatch(t, single, "b")
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := []struct {
+		pattern string
+		want    error
+	}{
+		{"(", regex.ErrUnmatchedLpar},
+		{")", regex.ErrUnmatchedRpar},
+		{"|abc", regex.ErrBareAlternation},
+		{"abc|", regex.ErrTrailingOperator},
+		{"()", regex.ErrEmptyExpression},
+		{"", regex.ErrEmptyExpression},
+		{"a||b", regex.ErrBareAlternation},
+		{"*a", regex.ErrMissingOperand},
+		{"[z-a]", regex.ErrInvalidClassRange},
+		{"[abc", regex.ErrUnterminatedClass},
+	}
+
+	for _, c := range cases {
+		_, err := regex.Compile(c.pattern)
+		if !errors.Is(err, c.want) {
+			t.Errorf("Compile(%#v): expected error %v, got %v", c.pattern, c.want, err)
+		}
+	}
+}
+
+func TestMustCompilePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected MustCompile(\"(\") to panic")
+		}
+	}()
+	regex.MustCompile("(")
+}
+
+// TestRegexCacheReuseAcrossCalls exercises the same compiled Regex (and so
+// its DFA cache) against inputs of varying length, to make sure cached
+// transitions from one call can't leak into the anchor handling of another.
+func TestRegexCacheReuseAcrossCalls(t *testing.T) {
+	r := regex.MustCompile("^a+$")
+
+	assertMatch(t, r, "a")
+	assertMatch(t, r, "aaaa")
+	refuteMatch(t, r, "")
+	refuteMatch(t, r, "aaab")
+	assertMatch(t, r, "aa")
+	refuteMatch(t, r, "baa")
+}
+
+func TestFind(t *testing.T) {
+	r := regex.MustCompile("a+b")
+
+	start, end, ok := regex.Find(r, "xxaaabyy")
+	if !ok || start != 2 || end != 6 {
+		t.Errorf("Expected match at [2, 6], got [%d, %d], ok=%v", start, end, ok)
+	}
+
+	if _, _, ok := regex.Find(r, "xyz"); ok {
+		t.Errorf("Expected no match")
+	}
+}
+
+func TestFindAll(t *testing.T) {
+	r := regex.MustCompile("a+")
+
+	matches := regex.FindAll(r, "a aa aaa")
+	expected := [][2]int{{0, 1}, {2, 4}, {5, 8}}
+	if !equalMatches(matches, expected) {
+		t.Errorf("Expected %v, got %v", expected, matches)
+	}
+}
+
+func TestFindAllEmptyMatches(t *testing.T) {
+	r := regex.MustCompile("a*")
+
+	// The trailing {2, 2} a naive reading of "a*" might expect is suppressed:
+	// it's an empty match sitting right where the {1, 2} match just ended,
+	// and stdlib regexp doesn't double-report that position either.
+	matches := regex.FindAll(r, "ba")
+	expected := [][2]int{{0, 0}, {1, 2}}
+	if !equalMatches(matches, expected) {
+		t.Errorf("Expected %v, got %v", expected, matches)
+	}
+}
+
+func TestFindAllUTF8(t *testing.T) {
+	r := regex.MustCompile("é+")
+
+	str := "café étoile"
+	matches := regex.FindAll(r, str)
+	expected := [][2]int{{3, 5}, {6, 8}}
+	if !equalMatches(matches, expected) {
+		t.Errorf("Expected %v, got %v", expected, matches)
+	}
+	for _, m := range matches {
+		if str[m[0]:m[1]] != "é" {
+			t.Errorf("Expected %#v, got %#v", "é", str[m[0]:m[1]])
+		}
+	}
+}
+
+func equalMatches(a, b [][2]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// BenchmarkMatchSmall exercises Match repeatedly on the same compiled Regex.
+// Only the first call has to build the DFA transitions from scratch; every
+// call after that is reusing cached dfaCache entries.
+func BenchmarkMatchSmall(b *testing.B) {
+	r := regex.MustCompile("a(b|cd|ef)g")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		regex.Match(r, "acdg")
+	}
+}
+
+// BenchmarkMatchPathological uses a chain of optional a's followed by a run
+// of required a's (`a?a?a?...aaa`), the classic pattern that blows up
+// backtracking engines. This engine never backtracks, but it does explore
+// many distinct NFA-state-sets per input position, which is exactly what the
+// DFA cache is meant to amortize across repeated matches. There's no
+// pre-cache baseline left in this tree to compare against directly (the
+// uncached subset construction this replaced isn't preserved anywhere); the
+// speedup it demonstrates is the gap between this benchmark's first
+// iteration, which pays for the subset construction, and every iteration
+// after it, which doesn't.
+func BenchmarkMatchPathological(b *testing.B) {
+	pattern := strings.Repeat("a?", 20) + strings.Repeat("a", 20)
+	str := strings.Repeat("a", 20)
+	r := regex.MustCompile(pattern)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		regex.Match(r, str)
+	}
+}
+
 func assertMatch(t *testing.T, r regex.Regex, str string) {
 	if !regex.Match(r, str) {
 		t.Errorf("Expected %#v to match", str)